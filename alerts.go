@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/buger/jsonparser"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var alertDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "alert"),
+	"A currently open Conviva alert for this filter; the value is always 1.",
+	[]string{"conviva_filter_id", "alert_type", "severity"}, nil,
+)
+
+// alertsCollector hits Conviva's /alerts endpoint and reports one series per
+// currently open alert.
+type alertsCollector struct{}
+
+// Name identifies this collector for the `module` query parameter.
+func (alertsCollector) Name() string { return "alerts" }
+
+// Describe sends the alert metric descriptor to ch.
+func (alertsCollector) Describe(ch chan<- *prometheus.Desc, target probeTarget) {
+	ch <- alertDesc
+}
+
+// Collect calls the Conviva API and returns one sample per open alert.
+func (alertsCollector) Collect(ctx context.Context, client *http.Client, target probeTarget) ([]sample, error) {
+	query := url.Values{"filter_id": {target.filterID}}
+	endpoint := target.baseURL + "/insights/" + target.apiVersion + "/alerts?" + query.Encode()
+
+	body, err := doConvivaRequest(ctx, client, target, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []sample
+	var parseErr error
+	_, err = jsonparser.ArrayEach(body, func(alert []byte, dataType jsonparser.ValueType, offset int, err error) {
+		if err != nil {
+			parseErr = &parseError{fmt.Errorf("parsing alerts: %w", err)}
+			return
+		}
+
+		alertType, _ := jsonparser.GetString(alert, "type")
+		severity, _ := jsonparser.GetString(alert, "severity")
+		samples = append(samples, sample{desc: alertDesc, value: 1, labelValues: []string{target.filterID, alertType, severity}})
+	}, "alerts")
+	if err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	return samples, nil
+}
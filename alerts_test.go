@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAlertsCollectorCollectMalformedResponse(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", tokenHandler)
+	mux.HandleFunc("/insights/v3/alerts", func(w http.ResponseWriter, r *http.Request) {
+		// A response body with no "alerts" array at all, as if the API
+		// returned something unexpected or truncated.
+		w.Write([]byte(`{"not_alerts": []}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	target := probeTarget{
+		baseURL:     srv.URL,
+		apiVersion:  "v3",
+		tokenSource: newTokenSource(srv.URL+"/oauth2/token", "id", "secret"),
+		filterID:    "f1",
+	}
+
+	samples, err := alertsCollector{}.Collect(context.Background(), srv.Client(), target)
+	if err == nil {
+		t.Fatalf("want an error for a malformed Conviva response, got samples=%v", samples)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/buger/jsonparser"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var audienceVariableLabels = []string{"conviva_filter_id"}
+
+var audienceMetricDescriptions = map[string]*prometheus.Desc{
+	"concurrent_plays": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "audience", "concurrent_plays"),
+		"Concurrent Plays is the number of plays happening at the same time across the filter's audience.", audienceVariableLabels, nil,
+	),
+	"unique_devices": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "audience", "unique_devices"),
+		"Unique Devices is the number of distinct devices playing video in the selected interval.", audienceVariableLabels, nil,
+	),
+}
+
+// audienceCollector hits Conviva's audience-metrics endpoint for filter-wide
+// concurrency and device-reach numbers.
+type audienceCollector struct{}
+
+// Name identifies this collector for the `module` query parameter.
+func (audienceCollector) Name() string { return "audience" }
+
+// Describe sends the audience metric descriptors to ch.
+func (audienceCollector) Describe(ch chan<- *prometheus.Desc, target probeTarget) {
+	for _, desc := range audienceMetricDescriptions {
+		ch <- desc
+	}
+}
+
+// Collect calls the Conviva API and returns one sample per audience metric.
+func (audienceCollector) Collect(ctx context.Context, client *http.Client, target probeTarget) ([]sample, error) {
+	query := url.Values{"filter_id": {target.filterID}}
+	endpoint := target.baseURL + "/insights/" + target.apiVersion + "/audience-metrics/real-time?" + query.Encode()
+
+	body, err := doConvivaRequest(ctx, client, target, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	filterTitle, err := jsonparser.GetString(body, "_meta", "filter_info", "id")
+	if err != nil {
+		filterTitle = target.filterID
+	}
+
+	samples := make([]sample, 0, len(audienceMetricDescriptions))
+	for name, desc := range audienceMetricDescriptions {
+		value, err := jsonparser.GetFloat(body, name)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, sample{desc: desc, value: value, labelValues: []string{filterTitle}})
+	}
+
+	return samples, nil
+}
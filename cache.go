@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultCacheTTL is used when the config file doesn't set cache_ttl_seconds.
+const defaultCacheTTL = 45 * time.Second
+
+var (
+	cacheHitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: exporterNamespace,
+		Name:      "cache_hits_total",
+		Help:      "Total number of module scrapes served from the in-process cache.",
+	})
+	cacheMissesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: exporterNamespace,
+		Name:      "cache_misses_total",
+		Help:      "Total number of module scrapes that required a Conviva API call.",
+	})
+	apiRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: exporterNamespace,
+		Name:      "api_requests_total",
+		Help:      "Total number of requests made to the Conviva API, by outcome.",
+	}, []string{"status"})
+)
+
+func init() {
+	prometheus.MustRegister(cacheHitsTotal, cacheMissesTotal, apiRequestsTotal)
+}
+
+// cacheEntry is one memoized moduleCollector.Collect result.
+type cacheEntry struct {
+	samples   []sample
+	expiresAt time.Time
+}
+
+// scrapeCache memoizes moduleCollector.Collect results, keyed by module and
+// target, for ttl. Conviva's real-time endpoints are rate-limited and
+// minute-granular, while Prometheus may scrape the exporter far more often
+// and from several targets whose filter/dimension/metric selection overlaps,
+// so results are reused within ttl instead of re-fetched on every scrape.
+// Concurrent requests for the same key are coalesced into a single upstream
+// call via singleflight, so a cache miss under load still only hits the
+// Conviva API once.
+type scrapeCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+
+	group singleflight.Group
+}
+
+// newScrapeCache returns a scrapeCache that reuses results for ttl, or
+// defaultCacheTTL if ttl is zero or negative.
+func newScrapeCache(ttl time.Duration) *scrapeCache {
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	return &scrapeCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// Collect returns c.Collect(ctx, client, target)'s samples, from cache if a
+// fresh entry exists for this module and target, otherwise fetched fresh and
+// cached for ttl. Errors are never cached, so a failed scrape doesn't wedge
+// the target for the rest of the TTL window.
+func (sc *scrapeCache) Collect(ctx context.Context, client *http.Client, c moduleCollector, target probeTarget) ([]sample, error) {
+	key := cacheKey(c.Name(), target)
+
+	sc.mu.Lock()
+	entry, ok := sc.entries[key]
+	sc.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		cacheHitsTotal.Inc()
+		return entry.samples, nil
+	}
+
+	cacheMissesTotal.Inc()
+	v, err, _ := sc.group.Do(key, func() (interface{}, error) {
+		samples, err := c.Collect(ctx, client, target)
+		if err != nil {
+			apiRequestsTotal.WithLabelValues("error").Inc()
+			return nil, err
+		}
+		apiRequestsTotal.WithLabelValues("success").Inc()
+
+		sc.mu.Lock()
+		sc.entries[key] = cacheEntry{samples: samples, expiresAt: time.Now().Add(sc.ttl)}
+		sc.mu.Unlock()
+
+		return samples, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]sample), nil
+}
+
+// cacheKey identifies a scrape uniquely enough to memoize it: which
+// collector, which filter/dimension, and which metrics were requested.
+func cacheKey(module string, target probeTarget) string {
+	return module + "|" + target.filterID + "|" + target.dimension + "|" + strings.Join(target.metricNames, ",")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// countingCollector is a moduleCollector whose Collect blocks until released,
+// so tests can control exactly when a scrape "completes" and count how many
+// times the upstream call actually happened.
+type countingCollector struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (c *countingCollector) Name() string                                       { return "counting" }
+func (c *countingCollector) Describe(ch chan<- *prometheus.Desc, _ probeTarget) {}
+func (c *countingCollector) Collect(ctx context.Context, client *http.Client, target probeTarget) ([]sample, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if c.release != nil {
+		<-c.release
+	}
+	return []sample{{value: float64(atomic.LoadInt32(&c.calls))}}, nil
+}
+
+func TestScrapeCacheCollectHit(t *testing.T) {
+	sc := newScrapeCache(time.Minute)
+	c := &countingCollector{}
+	target := probeTarget{filterID: "f1"}
+
+	if _, err := sc.Collect(context.Background(), nil, c, target); err != nil {
+		t.Fatalf("first Collect: %v", err)
+	}
+	if _, err := sc.Collect(context.Background(), nil, c, target); err != nil {
+		t.Fatalf("second Collect: %v", err)
+	}
+	if got := atomic.LoadInt32(&c.calls); got != 1 {
+		t.Fatalf("want 1 upstream call within the TTL window, got %d", got)
+	}
+}
+
+func TestScrapeCacheCollectExpiry(t *testing.T) {
+	sc := newScrapeCache(time.Nanosecond)
+	c := &countingCollector{}
+	target := probeTarget{filterID: "f1"}
+
+	if _, err := sc.Collect(context.Background(), nil, c, target); err != nil {
+		t.Fatalf("first Collect: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := sc.Collect(context.Background(), nil, c, target); err != nil {
+		t.Fatalf("second Collect: %v", err)
+	}
+	if got := atomic.LoadInt32(&c.calls); got != 2 {
+		t.Fatalf("want a fresh upstream call once the entry expires, got %d calls", got)
+	}
+}
+
+func TestScrapeCacheCollectDistinctKeys(t *testing.T) {
+	sc := newScrapeCache(time.Minute)
+	c := &countingCollector{}
+
+	if _, err := sc.Collect(context.Background(), nil, c, probeTarget{filterID: "f1"}); err != nil {
+		t.Fatalf("Collect f1: %v", err)
+	}
+	if _, err := sc.Collect(context.Background(), nil, c, probeTarget{filterID: "f2"}); err != nil {
+		t.Fatalf("Collect f2: %v", err)
+	}
+	if got := atomic.LoadInt32(&c.calls); got != 2 {
+		t.Fatalf("distinct targets must not share a cache entry, got %d calls", got)
+	}
+}
+
+func TestScrapeCacheCollectSingleflight(t *testing.T) {
+	sc := newScrapeCache(time.Minute)
+	c := &countingCollector{release: make(chan struct{})}
+	target := probeTarget{filterID: "f1"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := sc.Collect(context.Background(), nil, c, target); err != nil {
+				t.Errorf("concurrent Collect: %v", err)
+			}
+		}()
+	}
+
+	// Give the goroutines a chance to all pile up on the in-flight call
+	// before releasing it, so the test actually exercises coalescing rather
+	// than five sequential calls that happen to race past each other.
+	time.Sleep(10 * time.Millisecond)
+	close(c.release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&c.calls); got != 1 {
+		t.Fatalf("concurrent scrapes for the same key should coalesce into 1 upstream call, got %d", got)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// probeTarget bundles the connection details and scrape target that a
+// moduleCollector needs to hit the Conviva API for one /probe request.
+type probeTarget struct {
+	baseURL       string
+	apiVersion    string
+	tokenSource   *tokenSource
+	filterID      string
+	dimension     string
+	metricNames   []string
+	metricConfigs map[string]MetricConfig
+	dedupe        *seenState
+
+	// queryMinutes and queryGranularity control the window requested from
+	// Conviva's time_series-returning endpoints (quality, realtime), set
+	// from the --query.minutes / --query.granularity flags.
+	queryMinutes     int
+	queryGranularity string
+}
+
+// sample is a single metric observation produced by a moduleCollector. If
+// timestamp is non-zero, it is emitted as the sample's own time rather than
+// Prometheus's default of "now" - used by collectors that walk historical
+// time_series buckets instead of only the latest one.
+type sample struct {
+	desc        *prometheus.Desc
+	value       float64
+	labelValues []string
+	timestamp   time.Time
+}
+
+// seenState tracks the newest timestamp already emitted for a given
+// dedupeKey, so a moduleCollector that walks a window of historical buckets
+// doesn't re-emit (and double-count, for rate() purposes) a bucket that a
+// previous scrape already reported.
+type seenState struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// newSeenState returns an empty seenState.
+func newSeenState() *seenState {
+	return &seenState{seen: make(map[string]time.Time)}
+}
+
+// Advance reports whether ts is newer than the last timestamp recorded for
+// key and, if so, records it as the new high-water mark. Entries from the
+// same key should be offered in chronological order within a scrape so each
+// is compared against the previous one's updated mark.
+func (s *seenState) Advance(key string, ts time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last, ok := s.seen[key]; ok && !ts.After(last) {
+		return false
+	}
+	s.seen[key] = ts
+	return true
+}
+
+// moduleCollector is implemented by each pluggable Conviva API integration
+// (quality metriclens, real-time totals, audience, alerts). /probe selects
+// which ones to run via repeated `module` query parameters, similar to how
+// blackbox_exporter selects probe modules.
+type moduleCollector interface {
+	// Name identifies the collector for the `module` query parameter.
+	Name() string
+	// Describe sends the collector's metric descriptors, so Exporter.Describe
+	// can report them ahead of any actual scrape. It takes target because
+	// collectors backed by target.metricConfigs (quality, realtime) may need
+	// to describe metrics only known at request time, e.g. ones added solely
+	// via conviva.yml's `metrics:` section.
+	Describe(ch chan<- *prometheus.Desc, target probeTarget)
+	// Collect scrapes the Conviva API for target and returns the resulting
+	// samples, or an error if the scrape failed.
+	Collect(ctx context.Context, client *http.Client, target probeTarget) ([]sample, error)
+}
+
+// moduleCollectors is the registry of available modules, keyed by the name
+// used in the `module` query parameter.
+var moduleCollectors = map[string]moduleCollector{
+	"quality":  qualityCollector{},
+	"realtime": realtimeCollector{},
+	"audience": audienceCollector{},
+	"alerts":   alertsCollector{},
+}
+
+// defaultModules is used when a /probe request doesn't specify any modules.
+var defaultModules = []string{"quality"}
+
+// doConvivaRequest performs an authenticated GET against the Conviva
+// Insights API and returns the raw response body. It's shared by every
+// moduleCollector so the token-fetch, transport and non-200 handling stay in
+// one place instead of four near-identical copies.
+func doConvivaRequest(ctx context.Context, client *http.Client, target probeTarget, endpoint string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := target.tokenSource.Token()
+	if err != nil {
+		return nil, &authError{fmt.Errorf("obtaining oauth2 token: %w", err)}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		reason, rerr := jsonparser.GetString(body, "name")
+		if rerr != nil {
+			reason = string(body)
+		}
+		return nil, fmt.Errorf("conviva API returned %s: %s", resp.Status, reason)
+	}
+
+	return body, nil
+}
+
+// sortByTimestamp orders rows oldest-first, regardless of the order the API
+// returned them in, so seenState.Advance's high-water mark only ever rejects
+// buckets a previous scrape has genuinely already reported.
+func sortByTimestamp[T any](rows []T, timestampOf func(T) time.Time) {
+	sort.Slice(rows, func(i, j int) bool { return timestampOf(rows[i]).Before(timestampOf(rows[j])) })
+}
+
+// authError marks an error that occurred while obtaining an OAuth2 token, so
+// scrapeErrorReason reports it under the "auth" reason.
+type authError struct{ err error }
+
+func (e *authError) Error() string { return e.err.Error() }
+func (e *authError) Unwrap() error { return e.err }
+
+// parseError marks an error that occurred while parsing an API response, so
+// scrapeErrorReason reports it under the "parse" reason.
+type parseError struct{ err error }
+
+func (e *parseError) Error() string { return e.err.Error() }
+func (e *parseError) Unwrap() error { return e.err }
+
+// scrapeErrorReason classifies an error returned by a moduleCollector's
+// Collect into one of the conviva_exporter_scrape_errors_total reasons:
+// "auth", "parse", "timeout", or the catch-all "http".
+func scrapeErrorReason(err error) string {
+	var ae *authError
+	if errors.As(err, &ae) {
+		return "auth"
+	}
+
+	var pe *parseError
+	if errors.As(err, &pe) {
+		return "parse"
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timeout"
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+
+	return "http"
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeenStateAdvance(t *testing.T) {
+	s := newSeenState()
+
+	t0 := time.Unix(1000, 0)
+	t1 := time.Unix(2000, 0)
+
+	if !s.Advance("a", t0) {
+		t.Fatal("first Advance for a new key should report true")
+	}
+	if s.Advance("a", t0) {
+		t.Fatal("re-offering the same timestamp should report false")
+	}
+	if s.Advance("a", t0.Add(-time.Second)) {
+		t.Fatal("offering an older timestamp should report false")
+	}
+	if !s.Advance("a", t1) {
+		t.Fatal("offering a newer timestamp should report true")
+	}
+
+	if !s.Advance("b", t0) {
+		t.Fatal("a distinct key should have its own high-water mark")
+	}
+}
+
+func TestSeenStateAdvancePerKey(t *testing.T) {
+	// Two metrics for the same filter/dimension/bucket must be tracked
+	// independently: advancing one key's mark must not affect the other's.
+	s := newSeenState()
+	ts := time.Unix(1000, 0)
+
+	if !s.Advance("quality|f|d|US|plays", ts) {
+		t.Fatal("first Advance for plays should report true")
+	}
+	if !s.Advance("quality|f|d|US|bitrate", ts) {
+		t.Fatal("a later /probe asking for a different metric at the same timestamp must still get its first chance")
+	}
+	if s.Advance("quality|f|d|US|plays", ts) {
+		t.Fatal("re-offering plays at the same timestamp should report false")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// MetricConfig describes how to pull a single metric's value out of the
+// Conviva API response: which JSON field under the metric object to read,
+// and an optional divisor to apply to it (e.g. bitrate is reported in bps
+// but exposed in kbps).
+type MetricConfig struct {
+	Field   string  `yaml:"field"`
+	Divisor float64 `yaml:"divisor,omitempty"`
+}
+
+// defaultMetricConfigs mirrors the field selection the exporter has always
+// used; entries here can be overridden per-metric from the config file, e.g.
+// to expose plays.count instead of plays.percentage.
+var defaultMetricConfigs = map[string]MetricConfig{
+	"attempts":                             {Field: "count"},
+	"bitrate":                              {Field: "bps", Divisor: 1000},
+	"connection_induced_rebuffering_ratio": {Field: "ratio"},
+	"ended_plays":                          {Field: "count"},
+	"exit_before_video_starts":             {Field: "percentage"},
+	"plays":                                {Field: "percentage"},
+	"rebuffering_ratio":                    {Field: "ratio"},
+	"video_playback_failures":              {Field: "percentage"},
+	"video_start_failures":                 {Field: "percentage"},
+	"video_start_time":                     {Field: "value"},
+}
+
+// TargetConfig is one filter_id/dimension pair that can be scraped via
+// /probe, along with an optional subset of metrics to request for it. When a
+// /probe request isn't explicit about which metrics to fetch, the matching
+// TargetConfig (if any) supplies the default.
+type TargetConfig struct {
+	FilterID  string   `yaml:"filter_id"`
+	Dimension string   `yaml:"dimension"`
+	Metrics   []string `yaml:"metrics,omitempty"`
+}
+
+// CredentialsConfig holds the Conviva API connection details. ClientID and
+// ClientSecret are used for the OAuth2 client_credentials grant, not HTTP
+// Basic Auth against the insights API itself.
+type CredentialsConfig struct {
+	BaseURL      string `yaml:"base_url"`
+	APIVersion   string `yaml:"api_version"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// TokenURL is the OAuth2 token endpoint. Defaults to "<base_url>/oauth2/token".
+	TokenURL string `yaml:"token_url,omitempty"`
+}
+
+// tokenURLOrDefault returns TokenURL, or the conventional "/oauth2/token"
+// path under BaseURL if it wasn't set explicitly.
+func (c CredentialsConfig) tokenURLOrDefault() string {
+	if c.TokenURL != "" {
+		return c.TokenURL
+	}
+	return c.BaseURL + "/oauth2/token"
+}
+
+// Config is the top-level structure of the --config.file YAML document.
+type Config struct {
+	Credentials CredentialsConfig       `yaml:"credentials"`
+	Metrics     map[string]MetricConfig `yaml:"metrics,omitempty"`
+	Targets     []TargetConfig          `yaml:"targets,omitempty"`
+	// CacheTTLSeconds bounds how long a /probe scrape result is reused for an
+	// identical filter_id/dimension/module/metric-set before hitting the
+	// Conviva API again. Defaults to defaultCacheTTL if unset.
+	CacheTTLSeconds int `yaml:"cache_ttl_seconds,omitempty"`
+}
+
+// cacheTTL returns the configured cache TTL, or defaultCacheTTL if unset.
+func (c *Config) cacheTTL() time.Duration {
+	if c.CacheTTLSeconds <= 0 {
+		return defaultCacheTTL
+	}
+	return time.Duration(c.CacheTTLSeconds) * time.Second
+}
+
+// FindTarget returns the configured target matching filterID/dimension, or
+// nil if none is configured for that pair.
+func (c *Config) FindTarget(filterID, dimension string) *TargetConfig {
+	for i := range c.Targets {
+		t := &c.Targets[i]
+		if t.FilterID == filterID && t.Dimension == dimension {
+			return t
+		}
+	}
+	return nil
+}
+
+// MetricConfigFor returns the effective MetricConfig for name, applying the
+// config file's override (if any) on top of the built-in default.
+func (c *Config) MetricConfigFor(name string) (MetricConfig, bool) {
+	if mc, ok := c.Metrics[name]; ok {
+		return mc, true
+	}
+	mc, ok := defaultMetricConfigs[name]
+	return mc, ok
+}
+
+// SafeConfig wraps Config behind a mutex so it can be hot-reloaded on SIGHUP
+// while /probe requests are being served concurrently. It also owns the
+// tokenSource derived from the current credentials and the scrapeCache
+// derived from the current cache_ttl_seconds, both shared across all
+// concurrent /probe scrapes so they don't each re-authenticate or re-fetch
+// the same data.
+type SafeConfig struct {
+	mu     sync.RWMutex
+	c      *Config
+	ts     *tokenSource
+	cache  *scrapeCache
+	dedupe *seenState
+}
+
+// Get returns the currently loaded configuration.
+func (sc *SafeConfig) Get() *Config {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.c
+}
+
+// TokenSource returns the tokenSource for the current credentials.
+func (sc *SafeConfig) TokenSource() *tokenSource {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.ts
+}
+
+// Cache returns the scrapeCache for the current cache_ttl_seconds.
+func (sc *SafeConfig) Cache() *scrapeCache {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.cache
+}
+
+// Dedupe returns the seenState tracking already-emitted historical points.
+func (sc *SafeConfig) Dedupe() *seenState {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.dedupe
+}
+
+// ReloadConfig loads the config file at path and, if it parses cleanly,
+// atomically swaps it in along with a fresh tokenSource for its credentials,
+// a fresh scrapeCache for its cache_ttl_seconds, and a fresh dedupe state.
+func (sc *SafeConfig) ReloadConfig(path string) error {
+	config, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	sc.c = config
+	sc.ts = newTokenSource(config.Credentials.tokenURLOrDefault(), config.Credentials.ClientID, config.Credentials.ClientSecret)
+	sc.cache = newScrapeCache(config.cacheTTL())
+	sc.dedupe = newSeenState()
+	sc.mu.Unlock()
+	return nil
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("parsing config file: %w", err)
+	}
+
+	if config.Credentials.BaseURL == "" {
+		return nil, fmt.Errorf("config: credentials.base_url is required")
+	}
+
+	return config, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestConfigMetricConfigForDefault(t *testing.T) {
+	c := &Config{}
+	mc, ok := c.MetricConfigFor("plays")
+	if !ok {
+		t.Fatal("want the built-in default for a known metric")
+	}
+	if mc != defaultMetricConfigs["plays"] {
+		t.Fatalf("want the built-in default %+v, got %+v", defaultMetricConfigs["plays"], mc)
+	}
+}
+
+func TestConfigMetricConfigForOverride(t *testing.T) {
+	c := &Config{
+		Metrics: map[string]MetricConfig{
+			"plays": {Field: "count"},
+		},
+	}
+	mc, ok := c.MetricConfigFor("plays")
+	if !ok {
+		t.Fatal("want ok for an overridden metric")
+	}
+	if mc.Field != "count" {
+		t.Fatalf("want the config file's override to take precedence over the built-in default, got %+v", mc)
+	}
+}
+
+func TestConfigMetricConfigForConfigOnly(t *testing.T) {
+	// A metric with no built-in default at all, added only via conviva.yml's
+	// `metrics:` section.
+	c := &Config{
+		Metrics: map[string]MetricConfig{
+			"concurrent-plays": {Field: "count"},
+		},
+	}
+	mc, ok := c.MetricConfigFor("concurrent-plays")
+	if !ok {
+		t.Fatal("want ok for a metric configured only in the config file")
+	}
+	if mc.Field != "count" {
+		t.Fatalf("want Field %q, got %+v", "count", mc)
+	}
+}
+
+func TestConfigMetricConfigForUnknown(t *testing.T) {
+	c := &Config{}
+	if _, ok := c.MetricConfigFor("no-such-metric"); ok {
+		t.Fatal("want ok=false for a metric with neither a default nor a config override")
+	}
+}
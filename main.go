@@ -1,16 +1,18 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"errors"
 	"flag"
-	"io"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
-	"github.com/buger/jsonparser"
-	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -19,14 +21,40 @@ import (
 
 const namespace = "conviva_experience_insights"
 
+// exporterNamespace prefixes the exporter's own operational metrics (scrape
+// errors, scrape duration), kept distinct from namespace so they can never
+// collide with a Conviva business metric of the same name.
+const exporterNamespace = "conviva_exporter"
+
+var (
+	scrapeErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: exporterNamespace,
+		Name:      "scrape_errors_total",
+		Help:      "Total number of Conviva API scrape errors, by reason.",
+	}, []string{"reason"})
+
+	lastScrapeDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: exporterNamespace,
+		Name:      "last_scrape_duration_seconds",
+		Help:      "Duration of the most recent /probe scrape, in seconds.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(scrapeErrorsTotal, lastScrapeDuration)
+}
+
 var (
 	tr = &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
-	client         = &http.Client{Transport: tr}
-	listenAddress  = flag.String("web.listen-address", ":8080", "Address to listen on for telemetry")
-	metricsPath    = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics")
-	variableLabels = []string{"conviva_filter_id", "metriclens_dimension_value"}
+	client        = &http.Client{Transport: tr}
+	listenAddress = flag.String("web.listen-address", ":8080", "Address to listen on for telemetry")
+	metricsPath   = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics")
+	configFile    = flag.String("config.file", "conviva.yml", "Path to configuration file.")
+
+	queryMinutes     = flag.Int("query.minutes", 2, "Size, in minutes, of the time_series window requested from the Conviva API.")
+	queryGranularity = flag.String("query.granularity", "PT1M", "ISO-8601 duration granularity of the time_series buckets requested from the Conviva API.")
 
 	exporterUp = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "up"),
@@ -46,290 +74,202 @@ var (
 		"ended-plays",
 		"connection-induced-rebuffering-ratio",
 	}
-
-	metricDescriptions = map[string]*prometheus.Desc{
-		"attempts": prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "attempts"),
-			"Attempts counts all attempts to play a video which are initiated when a viewer clicks play or a video auto-plays.", variableLabels, nil,
-		),
-		"bitrate": prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "average_bitrate"),
-			"Average bitrate calculates the bits played by the player. The bits played do not include bits in buffering or bits passed during paused video.", variableLabels, nil,
-		),
-		"connection_induced_rebuffering_ratio": prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "connection_induced_rebuffering_ratio"),
-			"Connection Induced Rebuffering Ratio (CIRR) measures the percentage of total video viewing time (playTime plus all rebuffering) during which viewers experienced nonseek rebuffering.", variableLabels, nil,
-		),
-		"ended_plays": prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "ended_plays"),
-			"An ended play is a play that ended during the selected interval. To count as an ended play, the viewing session must have at least one video frame that was viewed.", variableLabels, nil,
-		),
-		"exit_before_video_starts": prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "exits_before_video_start"),
-			"Exits Before Video Start (EBVS) measures the Attempts that terminated before the video started, without a reported fatal error.", variableLabels, nil,
-		),
-		"plays": prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "plays"),
-			"Plays (Successful Attempts) is counted when the viewer sees the first frame of video.", variableLabels, nil,
-		),
-		"rebuffering_ratio": prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "rebuffering_ratio"),
-			"Rebuffering Ratio measures the percentage of total video viewing time (playTime + rebufferingTime) during which viewers experienced rebuffering.", variableLabels, nil,
-		),
-		"video_playback_failures": prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "video_playback_failures"),
-			"Video playback failure occurs when video play terminates due to a playback error, such as video file corruption, insufficient streaming resources, or a sudden interruption in the video stream.", variableLabels, nil,
-		),
-		"video_start_failures": prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "video_start_failures"),
-			"Video Start Failures (VSF) measures how often Attempts terminated during video startup before the first video frame was played, and a fatal error was reported.", variableLabels, nil,
-		),
-		"video_start_time": prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "video_start_time"),
-			"Video Startup Time (VST) is the number of seconds between when the user clicks play or video auto-starts and when the first frame of a video is rendered.", variableLabels, nil,
-		),
-	}
 )
 
-// Metric has a name and a value
-type Metric struct {
-	metricName string
-	value      float64
-}
-
-// NewDimension allocates and initializes a new Metric
-func NewMetric() *Metric {
-	metric := &Metric{}
-	return metric
-}
-
-// Dimension represents a dimension and has a list of metrics
-type Dimension struct {
-	dimensionValue string
-	metrics        []*Metric
-}
-
-// NewDimension allocates and initializes a new Dimension
-func NewDimension() *Dimension {
-	dimension := &Dimension{}
-	return dimension
-}
-
-// MetricsData represents the API response
-type MetricsData struct {
-	filterTitle    string
-	dimensionTitle string
-	dimensions     []*Dimension
-}
-
-// NewMetricsData allocates and initializes a new QualityMetricLens
-func NewMetricsData() *MetricsData {
-	qualityMetriclensData := &MetricsData{}
-	qualityMetriclensData.dimensions = make([]*Dimension, 0)
-	return qualityMetriclensData
-}
-
-// Exporter is used to store metrics
+// Exporter runs a set of moduleCollectors against a single probeTarget and
+// reports the combined result, plus its own up gauge, to Prometheus. A fresh
+// Exporter is built per /probe request.
 type Exporter struct {
-	convivaBaseURL, convivaAPIVersion, convivaClientID, convivaClientSecret, convivaFilterID, convivaDimensionName string
+	ctx        context.Context
+	target     probeTarget
+	collectors []moduleCollector
+	cache      *scrapeCache
 }
 
-// NewExporter generates a new Exporter
-func NewExporter(convivaBaseURL string, convivaAPIVersion string, convivaClientID string, convivaClientSecret string, convivaFilterID string, convivaDimensionName string) *Exporter {
+// NewExporter generates a new Exporter that scrapes target through
+// collectors, memoizing each collector's result in cache. ctx bounds every
+// Conviva API call the scrape makes; callers should derive it from the
+// incoming /probe request so a hung upstream call doesn't block the HTTP
+// handler forever.
+func NewExporter(ctx context.Context, target probeTarget, collectors []moduleCollector, cache *scrapeCache) *Exporter {
 	return &Exporter{
-		convivaBaseURL:       convivaBaseURL,
-		convivaAPIVersion:    convivaAPIVersion,
-		convivaClientID:      convivaClientID,
-		convivaClientSecret:  convivaClientSecret,
-		convivaFilterID:      convivaFilterID,
-		convivaDimensionName: convivaDimensionName,
+		ctx:        ctx,
+		target:     target,
+		collectors: collectors,
+		cache:      cache,
 	}
 }
 
-// Describe provides the Conviva metrics to prometheus.Describe
+// Describe provides the descriptors of every selected module to prometheus.Describe
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	for _, desc := range metricDescriptions {
-		ch <- desc
-	}
 	ch <- exporterUp
+	for _, c := range e.collectors {
+		c.Describe(ch, e.target)
+	}
 }
 
 // Collect is called by the Prometheus Client library when a scrape is peformed
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	qualityMetriclensData, err := e.getQualityMetriclens(ch)
-	if err != nil {
-		log.Println("Got error from Conviva API")
-		log.Println(err)
-		// Set flag to indicate failed scrape
-		ch <- prometheus.MustNewConstMetric(exporterUp, prometheus.GaugeValue, 0)
-		return
-	}
-
-	// Set flag to indicate successful scrape
-	ch <- prometheus.MustNewConstMetric(exporterUp, prometheus.GaugeValue, 1)
-	e.updateMetrics(ch, qualityMetriclensData)
-}
+	start := time.Now()
+	up := 1.0
 
-// GetQualitySummaryAndUpdateMetrics calls the Conviva API and updates the metrics to Prometheus
-func (e *Exporter) getQualityMetriclens(ch chan<- prometheus.Metric) (*MetricsData, error) {
-	qualityMetriclensData := NewMetricsData()
-	qualityMetriclensEndpoint := e.convivaBaseURL + "/insights/" + e.convivaAPIVersion + "/real-time-metrics/custom-selection/group-by/" + e.convivaDimensionName + "?minutes=2&granularity=PT1M&filter_id=" + e.convivaFilterID
-
-	for i := 0; i < len(metrics); i++ {
-		qualityMetriclensEndpoint += "&metric=" + metrics[i]
-	}
+	for _, c := range e.collectors {
+		samples, err := e.cache.Collect(e.ctx, client, c, e.target)
+		if err != nil {
+			log.Printf("Got error from Conviva API (module=%s): %v", c.Name(), err)
+			scrapeErrorsTotal.WithLabelValues(scrapeErrorReason(err)).Inc()
+			up = 0
+			continue
+		}
 
-	req, err := http.NewRequest("GET", qualityMetriclensEndpoint, nil)
-	if err != nil {
-		return nil, err
+		for _, s := range samples {
+			m := prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue, s.value, s.labelValues...)
+			if !s.timestamp.IsZero() {
+				m = prometheus.NewMetricWithTimestamp(s.timestamp, m)
+			}
+			ch <- m
+		}
 	}
 
-	req.SetBasicAuth(e.convivaClientID, e.convivaClientSecret)
+	lastScrapeDuration.Set(time.Since(start).Seconds())
+	ch <- prometheus.MustNewConstMetric(exporterUp, prometheus.GaugeValue, up)
+}
 
-	// Make request and show output.
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// probeTimeoutOffset is subtracted from the scrape timeout Prometheus
+// advertises, so the exporter gives up on the Conviva API - and still has
+// time to render a partial result - before Prometheus gives up on us.
+const probeTimeoutOffset = 500 * time.Millisecond
+
+// defaultProbeTimeout bounds a /probe request when the caller doesn't send
+// X-Prometheus-Scrape-Timeout-Seconds, e.g. when hit directly rather than
+// through a Prometheus scrape.
+const defaultProbeTimeout = 10 * time.Second
+
+// probeTimeout returns how long probeHandler should allow a scrape to run
+// for, derived from Prometheus's X-Prometheus-Scrape-Timeout-Seconds header
+// if present, following the blackbox_exporter /probe convention.
+func probeTimeout(r *http.Request) time.Duration {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return defaultProbeTimeout
 	}
-
-	body, err := io.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		return nil, err
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		return defaultProbeTimeout
 	}
-
-	// Check if request failed
-	if resp.StatusCode != 200 {
-		reason, err := jsonparser.GetString(body, "name")
-		if err == nil {
-			err = errors.New("Invalid response from API. Reason: " + reason)
-		}
-		return nil, err
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout <= probeTimeoutOffset {
+		return timeout
 	}
+	return timeout - probeTimeoutOffset
+}
 
-	// Parse the dimension title
-	dimensionName, err := jsonparser.GetString(body, "_meta", "group_by_dimension", "description")
-	if err != nil {
-		dimensionName = "Unknown"
+// probeHandler builds a fresh Exporter for the filter_id/dimension pair given
+// in the query string, scrapes it into a request-scoped registry and renders
+// the result, following the blackbox_exporter /probe convention. This lets a
+// single exporter process serve any number of filter/dimension targets,
+// selected by Prometheus via relabel_configs, instead of one process per
+// filter. Which Conviva API(s) to hit is chosen via repeated `module` query
+// parameters, also blackbox_exporter-style; it defaults to defaultModules.
+func probeHandler(w http.ResponseWriter, r *http.Request, safeConfig *SafeConfig) {
+	ctx, cancel := context.WithTimeout(r.Context(), probeTimeout(r))
+	defer cancel()
+
+	config := safeConfig.Get()
+	params := r.URL.Query()
+
+	filterID := params.Get("filter_id")
+	dimension := params.Get("dimension")
+	if filterID == "" || dimension == "" {
+		http.Error(w, "filter_id and dimension query parameters are required", http.StatusBadRequest)
+		return
 	}
-	qualityMetriclensData.dimensionTitle = dimensionName
-	// Parse the filter title
-	filterId, err := jsonparser.GetString(body, "_meta", "filter_info", "id")
-	if err != nil {
-		dimensionName = "Unknown"
+
+	moduleNames := params["module"]
+	if len(moduleNames) == 0 {
+		moduleNames = defaultModules
 	}
-	qualityMetriclensData.filterTitle = filterId
 
-	// For each dimension row in a filter
-	jsonparser.ArrayEach(body, func(dimensionalDataRow []byte, dataType jsonparser.ValueType, offset int, err error) {
-		if err != nil {
-			log.Fatalln("Could not get time_series[0].dimensional_data")
+	collectors := make([]moduleCollector, 0, len(moduleNames))
+	for _, name := range moduleNames {
+		c, ok := moduleCollectors[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown module %q", name), http.StatusBadRequest)
 			return
 		}
+		collectors = append(collectors, c)
+	}
 
-		dimension := NewDimension()
-		dimension.dimensionValue, err = jsonparser.GetString(dimensionalDataRow, "dimension", "value")
-		if err != nil {
-			log.Fatalln("Could not get time_series[0].dimensional_data.dimension.value")
-			return
+	metricNames := params["metric"]
+	if len(metricNames) == 0 {
+		if target := config.FindTarget(filterID, dimension); target != nil {
+			metricNames = target.Metrics
 		}
+	}
 
-		// For each metric in the dimension row
-		jsonparser.ObjectEach(dimensionalDataRow, func(metricName []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
-			if err != nil {
-				log.Fatalln("Could not get time_series[0].dimensional_data.metrics")
-				return nil
-			}
-
-			metric := NewMetric()
-			metric.metricName = string(metricName)
-
-			switch metric.metricName {
-			case "attempts":
-				metric.value, err = jsonparser.GetFloat(value, "count")
-			case "bitrate":
-				bps, err := jsonparser.GetFloat(value, "bps")
-				if err != nil {
-					metric.value = bps / 1000
-				}
-			case "connection_induced_rebuffering_ratio":
-				metric.value, err = jsonparser.GetFloat(value, "ratio")
-			case "ended_plays":
-				metric.value, err = jsonparser.GetFloat(value, "count")
-			case "exit_before_video_starts":
-				metric.value, err = jsonparser.GetFloat(value, "percentage")
-			case "plays":
-				metric.value, err = jsonparser.GetFloat(value, "percentage")
-			case "rebuffering_ratio":
-				metric.value, err = jsonparser.GetFloat(value, "ratio")
-			case "video_playback_failures":
-				metric.value, err = jsonparser.GetFloat(value, "percentage")
-			case "video_start_failures":
-				metric.value, err = jsonparser.GetFloat(value, "percentage")
-			case "video_start_time":
-				metric.value, err = jsonparser.GetFloat(value, "value")
-			default:
-				break
-			}
+	// Union defaultMetricConfigs with config.Metrics so a metric added only
+	// under conviva.yml's `metrics:` section (not one of the built-in names)
+	// still gets a MetricConfig, instead of being silently dropped.
+	metricConfigs := make(map[string]MetricConfig, len(defaultMetricConfigs)+len(config.Metrics))
+	for name := range defaultMetricConfigs {
+		metricConfigs[name], _ = config.MetricConfigFor(name)
+	}
+	for name := range config.Metrics {
+		metricConfigs[name], _ = config.MetricConfigFor(name)
+	}
 
-			dimension.metrics = append(dimension.metrics, metric)
-			return nil
-		}, "metrics")
+	target := probeTarget{
+		baseURL:          config.Credentials.BaseURL,
+		apiVersion:       config.Credentials.APIVersion,
+		tokenSource:      safeConfig.TokenSource(),
+		filterID:         filterID,
+		dimension:        dimension,
+		metricNames:      metricNames,
+		metricConfigs:    metricConfigs,
+		dedupe:           safeConfig.Dedupe(),
+		queryMinutes:     *queryMinutes,
+		queryGranularity: *queryGranularity,
+	}
 
-		// dimension.metrics = metricsRow
-		qualityMetriclensData.dimensions = append(qualityMetriclensData.dimensions, dimension)
-	}, "time_series", "[0]", "dimensional_data")
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewExporter(ctx, target, collectors, safeConfig.Cache()))
 
-	return qualityMetriclensData, nil
-}
-
-// UpdateMetrics reports all metrics to Prometheus
-func (e *Exporter) updateMetrics(ch chan<- prometheus.Metric, data *MetricsData) {
-	// Set all the metrics to Prometheus. Iterate all dimensions
-	for j := 0; j < len(data.dimensions); j++ {
-		filterTitle := data.filterTitle
-		dimension := data.dimensions[j]
-
-		// Iterate all metrics in the dimension
-		for k := 0; k < len(dimension.metrics); k++ {
-			metric := dimension.metrics[k]
-			dimensionValue := dimension.dimensionValue
-			ch <- prometheus.MustNewConstMetric(
-				metricDescriptions[metric.metricName], prometheus.GaugeValue, metric.value, filterTitle, dimensionValue,
-			)
-		}
-	}
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 }
 
 func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Println("Error loading .env file, assume env variables are set.")
-	}
-
 	flag.Parse()
 
-	convivaBaseURL := os.Getenv("CONVIVA_BASE_URL")
-	convivaAPIVersion := os.Getenv("CONVIVA_API_VERSION")
-	convivaClientID := os.Getenv("CONVIVA_CLIENT_ID")
-	convivaClientSecret := os.Getenv("CONVIVA_CLIENT_SECRET")
-	convivaFilterID := os.Getenv("CONVIVA_FILTER_ID")
-	convivaDimensionName := os.Getenv("CONVIVA_DIMENSION_NAME")
-
-	if convivaBaseURL == "" {
-		log.Fatal("Error loading convivaBaseURL from env variables. Exiting.")
+	safeConfig := &SafeConfig{}
+	if err := safeConfig.ReloadConfig(*configFile); err != nil {
+		log.Fatalf("Error loading config file %s: %v", *configFile, err)
 	}
 
-	exporter := NewExporter(convivaBaseURL, convivaAPIVersion, convivaClientID, convivaClientSecret, convivaFilterID, convivaDimensionName)
-	prometheus.MustRegister(exporter)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := safeConfig.ReloadConfig(*configFile); err != nil {
+				log.Println("Error reloading config file:", err)
+				continue
+			}
+			log.Println("Reloaded config file")
+		}
+	}()
 
+	// /metrics only exposes the exporter's own process metrics; the actual
+	// Conviva scrape happens per-target against /probe.
 	http.Handle(*metricsPath, promhttp.Handler())
+	http.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
+		probeHandler(w, r, safeConfig)
+	})
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 	         <head><title>Conviva Experience Insights Prometheus Exporter</title></head>
 	         <body>
 	         <h1>Conviva Experience Insights Quality Summary Exporter</h1>
 	         <p><a href='` + *metricsPath + `'>Metrics</a></p>
+	         <p><a href='/probe?filter_id=FILTER_ID&dimension=DIMENSION'>Example probe</a></p>
 	         </body>
 	         </html>`))
 	})
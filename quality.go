@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var qualityVariableLabels = []string{"conviva_filter_id", "metriclens_dimension_value"}
+
+var metricDescriptions = map[string]*prometheus.Desc{
+	"attempts": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "attempts"),
+		"Attempts counts all attempts to play a video which are initiated when a viewer clicks play or a video auto-plays.", qualityVariableLabels, nil,
+	),
+	"bitrate": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "average_bitrate"),
+		"Average bitrate calculates the bits played by the player. The bits played do not include bits in buffering or bits passed during paused video.", qualityVariableLabels, nil,
+	),
+	"connection_induced_rebuffering_ratio": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "connection_induced_rebuffering_ratio"),
+		"Connection Induced Rebuffering Ratio (CIRR) measures the percentage of total video viewing time (playTime plus all rebuffering) during which viewers experienced nonseek rebuffering.", qualityVariableLabels, nil,
+	),
+	"ended_plays": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "ended_plays"),
+		"An ended play is a play that ended during the selected interval. To count as an ended play, the viewing session must have at least one video frame that was viewed.", qualityVariableLabels, nil,
+	),
+	"exit_before_video_starts": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "exits_before_video_start"),
+		"Exits Before Video Start (EBVS) measures the Attempts that terminated before the video started, without a reported fatal error.", qualityVariableLabels, nil,
+	),
+	"plays": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "plays"),
+		"Plays (Successful Attempts) is counted when the viewer sees the first frame of video.", qualityVariableLabels, nil,
+	),
+	"rebuffering_ratio": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "rebuffering_ratio"),
+		"Rebuffering Ratio measures the percentage of total video viewing time (playTime + rebufferingTime) during which viewers experienced rebuffering.", qualityVariableLabels, nil,
+	),
+	"video_playback_failures": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "video_playback_failures"),
+		"Video playback failure occurs when video play terminates due to a playback error, such as video file corruption, insufficient streaming resources, or a sudden interruption in the video stream.", qualityVariableLabels, nil,
+	),
+	"video_start_failures": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "video_start_failures"),
+		"Video Start Failures (VSF) measures how often Attempts terminated during video startup before the first video frame was played, and a fatal error was reported.", qualityVariableLabels, nil,
+	),
+	"video_start_time": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "video_start_time"),
+		"Video Startup Time (VST) is the number of seconds between when the user clicks play or video auto-starts and when the first frame of a video is rendered.", qualityVariableLabels, nil,
+	),
+}
+
+// descFor returns the Desc for a quality metric name: the documented one
+// from metricDescriptions if known, or a generic one otherwise - so a metric
+// added only via conviva.yml's `metrics:` section, with no matching entry
+// here, is still exposed instead of being silently dropped.
+func descFor(name string) *prometheus.Desc {
+	if desc, ok := metricDescriptions[name]; ok {
+		return desc
+	}
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", strings.ReplaceAll(name, "-", "_")),
+		"Conviva metric "+name+", configured via conviva.yml.", qualityVariableLabels, nil,
+	)
+}
+
+// dimensionRow is one grouped row of a metriclens response: the time_series
+// bucket it was reported for, a dimension value (e.g. one city), and the
+// metrics reported for it.
+type dimensionRow struct {
+	timestamp time.Time
+	value     string
+	metrics   map[string]float64
+}
+
+// qualityCollector hits the MetricLens real-time-metrics/custom-selection
+// group-by endpoint, Conviva's per-dimension quality summary.
+type qualityCollector struct{}
+
+// Name identifies this collector for the `module` query parameter.
+func (qualityCollector) Name() string { return "quality" }
+
+// Describe sends the quality metric descriptors to ch, including one for
+// every metric in target.metricConfigs beyond the documented set.
+func (qualityCollector) Describe(ch chan<- *prometheus.Desc, target probeTarget) {
+	for name := range target.metricConfigs {
+		ch <- descFor(name)
+	}
+}
+
+// Collect calls the Conviva API and returns one sample per metric per
+// dimension value per historical time_series bucket not already reported by
+// a previous scrape of this target.
+func (qualityCollector) Collect(ctx context.Context, client *http.Client, target probeTarget) ([]sample, error) {
+	metricNames := target.metricNames
+	if len(metricNames) == 0 {
+		metricNames = metrics[:]
+	}
+
+	query := url.Values{
+		"minutes":     {strconv.Itoa(target.queryMinutes)},
+		"granularity": {target.queryGranularity},
+		"filter_id":   {target.filterID},
+		"metric":      metricNames,
+	}
+	endpoint := target.baseURL + "/insights/" + target.apiVersion + "/real-time-metrics/custom-selection/group-by/" +
+		url.PathEscape(target.dimension) + "?" + query.Encode()
+
+	body, err := doConvivaRequest(ctx, client, target, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the filter title; it's what the API considers this filter_id's
+	// canonical id, and is what we label the resulting series with.
+	filterTitle, err := jsonparser.GetString(body, "_meta", "filter_info", "id")
+	if err != nil {
+		filterTitle = target.filterID
+	}
+
+	var rows []dimensionRow
+	var parseErr error
+	jsonparser.ArrayEach(body, func(timeSeriesEntry []byte, dataType jsonparser.ValueType, offset int, err error) {
+		if err != nil {
+			parseErr = &parseError{fmt.Errorf("parsing time_series: %w", err)}
+			return
+		}
+
+		timestampSecs, err := jsonparser.GetInt(timeSeriesEntry, "timestamp")
+		if err != nil {
+			parseErr = &parseError{fmt.Errorf("parsing time_series.timestamp: %w", err)}
+			return
+		}
+		bucketTime := time.Unix(timestampSecs, 0).UTC()
+
+		jsonparser.ArrayEach(timeSeriesEntry, func(dimensionalDataRow []byte, dataType jsonparser.ValueType, offset int, err error) {
+			if err != nil {
+				parseErr = &parseError{fmt.Errorf("parsing dimensional_data: %w", err)}
+				return
+			}
+
+			dimensionValue, err := jsonparser.GetString(dimensionalDataRow, "dimension", "value")
+			if err != nil {
+				parseErr = &parseError{fmt.Errorf("parsing dimensional_data.dimension.value: %w", err)}
+				return
+			}
+
+			row := dimensionRow{timestamp: bucketTime, value: dimensionValue, metrics: map[string]float64{}}
+			jsonparser.ObjectEach(dimensionalDataRow, func(metricName []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+				name := string(metricName)
+				mc, ok := target.metricConfigs[name]
+				if !ok {
+					return nil
+				}
+
+				v, err := jsonparser.GetFloat(value, mc.Field)
+				if err != nil {
+					return nil
+				}
+				if mc.Divisor != 0 {
+					v /= mc.Divisor
+				}
+				row.metrics[name] = v
+				return nil
+			}, "metrics")
+
+			rows = append(rows, row)
+		}, "dimensional_data")
+	}, "time_series")
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	sortByTimestamp(rows, func(r dimensionRow) time.Time { return r.timestamp })
+
+	samples := make([]sample, 0, len(rows)*len(metricNames))
+	for _, row := range rows {
+		for name, value := range row.metrics {
+			// Dedupe per metric, not just per dimension value/bucket: two
+			// /probe calls for the same filter_id/dimension can request
+			// different metric subsets, and a metric a previous scrape
+			// never actually fetched must still get its first chance here.
+			dedupeKey := "quality|" + target.filterID + "|" + target.dimension + "|" + row.value + "|" + name
+			if !target.dedupe.Advance(dedupeKey, row.timestamp) {
+				continue
+			}
+			samples = append(samples, sample{desc: descFor(name), value: value, labelValues: []string{filterTitle, row.value}, timestamp: row.timestamp})
+		}
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQualityCollectorCollectMalformedResponse(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", tokenHandler)
+	mux.HandleFunc("/insights/v3/real-time-metrics/custom-selection/group-by/", func(w http.ResponseWriter, r *http.Request) {
+		// A time_series entry missing its timestamp field, as if the API
+		// returned a truncated or otherwise malformed response body.
+		w.Write([]byte(`{"time_series": [{"dimensional_data": []}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	target := probeTarget{
+		baseURL:       srv.URL,
+		apiVersion:    "v3",
+		tokenSource:   newTokenSource(srv.URL+"/oauth2/token", "id", "secret"),
+		filterID:      "f1",
+		dimension:     "country",
+		metricConfigs: defaultMetricConfigs,
+	}
+
+	_, err := qualityCollector{}.Collect(context.Background(), srv.Client(), target)
+	if err == nil {
+		t.Fatal("want an error for a malformed Conviva response, got nil")
+	}
+	var pe *parseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("want a *parseError, got %T: %v", err, err)
+	}
+}
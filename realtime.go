@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var realtimeVariableLabels = []string{"conviva_filter_id"}
+
+var realtimeMetricDescriptions = map[string]*prometheus.Desc{
+	"attempts": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "realtime", "attempts"),
+		"Attempts counts all attempts to play a video which are initiated when a viewer clicks play or a video auto-plays, filter-wide.", realtimeVariableLabels, nil,
+	),
+	"bitrate": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "realtime", "average_bitrate"),
+		"Average bitrate calculates the bits played by the player, filter-wide.", realtimeVariableLabels, nil,
+	),
+	"ended_plays": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "realtime", "ended_plays"),
+		"An ended play is a play that ended during the selected interval, filter-wide.", realtimeVariableLabels, nil,
+	),
+	"plays": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "realtime", "plays"),
+		"Plays (Successful Attempts) is counted when the viewer sees the first frame of video, filter-wide.", realtimeVariableLabels, nil,
+	),
+	"rebuffering_ratio": prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "realtime", "rebuffering_ratio"),
+		"Rebuffering Ratio measures the percentage of total video viewing time during which viewers experienced rebuffering, filter-wide.", realtimeVariableLabels, nil,
+	),
+}
+
+// realtimeDescFor returns the Desc for a real-time metric name: the
+// documented one from realtimeMetricDescriptions if known, or a generic one
+// otherwise - so a metric added only via conviva.yml's `metrics:` section,
+// with no matching entry here, is still exposed instead of being silently
+// dropped.
+func realtimeDescFor(name string) *prometheus.Desc {
+	if desc, ok := realtimeMetricDescriptions[name]; ok {
+		return desc
+	}
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "realtime", strings.ReplaceAll(name, "-", "_")),
+		"Conviva metric "+name+", configured via conviva.yml, filter-wide.", realtimeVariableLabels, nil,
+	)
+}
+
+// realtimeCollector hits the un-grouped real-time-metrics/custom-selection
+// endpoint: one total per metric per filter_id, with no group-by dimension.
+type realtimeCollector struct{}
+
+// Name identifies this collector for the `module` query parameter.
+func (realtimeCollector) Name() string { return "realtime" }
+
+// Describe sends the real-time metric descriptors to ch, including one for
+// every metric in target.metricConfigs beyond the documented set.
+func (realtimeCollector) Describe(ch chan<- *prometheus.Desc, target probeTarget) {
+	for name := range target.metricConfigs {
+		ch <- realtimeDescFor(name)
+	}
+}
+
+// Collect calls the Conviva API and returns one sample per metric per
+// historical time_series bucket not already reported by a previous scrape of
+// this target, without any dimensional breakdown.
+func (realtimeCollector) Collect(ctx context.Context, client *http.Client, target probeTarget) ([]sample, error) {
+	metricNames := target.metricNames
+	if len(metricNames) == 0 {
+		metricNames = metrics[:]
+	}
+
+	query := url.Values{
+		"minutes":     {strconv.Itoa(target.queryMinutes)},
+		"granularity": {target.queryGranularity},
+		"filter_id":   {target.filterID},
+		"metric":      metricNames,
+	}
+	endpoint := target.baseURL + "/insights/" + target.apiVersion + "/real-time-metrics/custom-selection?" + query.Encode()
+
+	body, err := doConvivaRequest(ctx, client, target, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	filterTitle, err := jsonparser.GetString(body, "_meta", "filter_info", "id")
+	if err != nil {
+		filterTitle = target.filterID
+	}
+
+	type bucket struct {
+		timestamp time.Time
+		metrics   map[string]float64
+	}
+
+	var buckets []bucket
+	var parseErr error
+	jsonparser.ArrayEach(body, func(timeSeriesEntry []byte, dataType jsonparser.ValueType, offset int, err error) {
+		if err != nil {
+			parseErr = &parseError{fmt.Errorf("parsing time_series: %w", err)}
+			return
+		}
+
+		timestampSecs, err := jsonparser.GetInt(timeSeriesEntry, "timestamp")
+		if err != nil {
+			parseErr = &parseError{fmt.Errorf("parsing time_series.timestamp: %w", err)}
+			return
+		}
+
+		b := bucket{timestamp: time.Unix(timestampSecs, 0).UTC(), metrics: map[string]float64{}}
+		jsonparser.ObjectEach(timeSeriesEntry, func(metricName []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+			name := string(metricName)
+			mc, ok := target.metricConfigs[name]
+			if !ok {
+				return nil
+			}
+
+			v, err := jsonparser.GetFloat(value, mc.Field)
+			if err != nil {
+				return nil
+			}
+			if mc.Divisor != 0 {
+				v /= mc.Divisor
+			}
+			b.metrics[name] = v
+			return nil
+		}, "metrics")
+
+		buckets = append(buckets, b)
+	}, "time_series")
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	sortByTimestamp(buckets, func(b bucket) time.Time { return b.timestamp })
+
+	var samples []sample
+	for _, b := range buckets {
+		for name, value := range b.metrics {
+			// Dedupe per metric, not just per bucket: two /probe calls for
+			// the same filter_id can request different metric subsets, and
+			// a metric a previous scrape never actually fetched must still
+			// get its first chance here.
+			dedupeKey := "realtime|" + target.filterID + "|" + name
+			if !target.dedupe.Advance(dedupeKey, b.timestamp) {
+				continue
+			}
+			samples = append(samples, sample{desc: realtimeDescFor(name), value: value, labelValues: []string{filterTitle}, timestamp: b.timestamp})
+		}
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealtimeCollectorCollectMalformedResponse(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", tokenHandler)
+	mux.HandleFunc("/insights/v3/real-time-metrics/custom-selection", func(w http.ResponseWriter, r *http.Request) {
+		// A time_series entry missing its timestamp field, as if the API
+		// returned a truncated or otherwise malformed response body.
+		w.Write([]byte(`{"time_series": [{}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	target := probeTarget{
+		baseURL:       srv.URL,
+		apiVersion:    "v3",
+		tokenSource:   newTokenSource(srv.URL+"/oauth2/token", "id", "secret"),
+		filterID:      "f1",
+		metricConfigs: defaultMetricConfigs,
+	}
+
+	_, err := realtimeCollector{}.Collect(context.Background(), srv.Client(), target)
+	if err == nil {
+		t.Fatal("want an error for a malformed Conviva response, got nil")
+	}
+	var pe *parseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("want a *parseError, got %T: %v", err, err)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// tokenHandler answers any /oauth2/token request with a valid, long-lived
+// access token, so a Collect test's target can authenticate without hitting
+// the real Conviva OAuth2 endpoint.
+func tokenHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "test-token",
+		"expires_in":   3600,
+	})
+}
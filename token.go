@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenRefreshSkew is how long before its reported expiry a cached token is
+// treated as stale, so a scrape never races an imminent expiration.
+const tokenRefreshSkew = 30 * time.Second
+
+// tokenSource obtains and caches an OAuth2 bearer token for the Conviva
+// Metrics v3 API using the client_credentials grant. It is safe for
+// concurrent use so a single token can be shared across concurrent /probe
+// scrapes instead of each one re-authenticating.
+type tokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// newTokenSource returns a tokenSource for the given OAuth2 token endpoint
+// and client credentials.
+func newTokenSource(tokenURL string, clientID string, clientSecret string) *tokenSource {
+	return &tokenSource{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+	}
+}
+
+// Token returns a valid bearer token, fetching or refreshing it if the
+// cached one is missing or about to expire.
+func (ts *tokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && time.Now().Before(ts.expiresAt.Add(-tokenRefreshSkew)) {
+		return ts.token, nil
+	}
+
+	token, expiresIn, err := ts.fetchToken()
+	if err != nil {
+		return "", err
+	}
+
+	ts.token = token
+	ts.expiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	return ts.token, nil
+}
+
+// fetchToken performs the client_credentials grant against the OAuth2 token
+// endpoint and returns the access token and its lifetime in seconds.
+func (ts *tokenSource) fetchToken() (string, int, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+
+	req, err := http.NewRequest("POST", ts.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(ts.clientID, ts.clientSecret)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("oauth2 token request to %s failed with status %d", ts.tokenURL, resp.StatusCode)
+	}
+
+	var tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return "", 0, fmt.Errorf("decoding oauth2 token response: %w", err)
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", 0, fmt.Errorf("oauth2 token response from %s did not include an access_token", ts.tokenURL)
+	}
+
+	return tokenResponse.AccessToken, tokenResponse.ExpiresIn, nil
+}
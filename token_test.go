@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCountingTokenServer(expiresIn int) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token-" + time.Now().Add(time.Duration(n)).String(),
+			"expires_in":   expiresIn,
+		})
+	}))
+	return srv, &calls
+}
+
+func TestTokenSourceTokenCaches(t *testing.T) {
+	srv, calls := newCountingTokenServer(3600)
+	defer srv.Close()
+
+	ts := newTokenSource(srv.URL, "id", "secret")
+	if _, err := ts.Token(); err != nil {
+		t.Fatalf("first Token: %v", err)
+	}
+	if _, err := ts.Token(); err != nil {
+		t.Fatalf("second Token: %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Fatalf("want 1 upstream token request while the cached token is fresh, got %d", got)
+	}
+}
+
+func TestTokenSourceTokenRefreshesWithinSkew(t *testing.T) {
+	// expires_in is shorter than tokenRefreshSkew, so the very first cached
+	// token should already be considered stale on the next call.
+	srv, calls := newCountingTokenServer(1)
+	defer srv.Close()
+
+	ts := newTokenSource(srv.URL, "id", "secret")
+	if _, err := ts.Token(); err != nil {
+		t.Fatalf("first Token: %v", err)
+	}
+	if _, err := ts.Token(); err != nil {
+		t.Fatalf("second Token: %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Fatalf("want a fresh token request once the cached one is within tokenRefreshSkew of expiring, got %d calls", got)
+	}
+}
+
+func TestTokenSourceTokenFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	ts := newTokenSource(srv.URL, "id", "secret")
+	if _, err := ts.Token(); err == nil {
+		t.Fatal("want an error when the OAuth2 endpoint rejects the client credentials")
+	}
+}